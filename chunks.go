@@ -0,0 +1,179 @@
+package mdweb
+
+import "fmt"
+import "regexp"
+import "strings"
+
+// A ChunkLine is one line of a Chunk's body, carrying forward Line.Code and
+// Line.IsChunkRef so ExpandChunk can tell an actual <<name>> reference from
+// code that merely looks like one (SyntaxFenced has no reference convention,
+// so it never sets IsChunkRef).
+type ChunkLine struct {
+	Code string
+	IsChunkRef bool
+}
+
+// A Chunk is one contiguous definition of a named code region: the Lines
+// woven under a single appearance of <<name>>. A chunk name can be defined
+// more than once in a file; ChunkIndex concatenates them in source order.
+type Chunk struct {
+	Name string
+	Lines []ChunkLine
+}
+
+// ChunkIndex maps a chunk name to every definition of it, in source order.
+type ChunkIndex map[string][]Chunk
+
+// BuildChunkIndex runs the first tangling pass: grouping a file's lines into
+// Chunks by CodeTarget, without expanding any <<name>> references found
+// inside them. lines is typically drained from ProcessFile or ProcessFS.
+func BuildChunkIndex(lines []Line) ChunkIndex {
+	index := make(ChunkIndex)
+
+	var name string
+	var chunkLines []ChunkLine
+
+	flush := func () {
+		if name != "" {
+			index[name] = append(index[name], Chunk{Name: name, Lines: chunkLines})
+		}
+		name = ""
+		chunkLines = nil
+	}
+
+	for _, line := range lines {
+		if line.CodeTarget == "" {
+			flush()
+			continue
+		}
+
+		if line.CodeTarget != name {
+			flush()
+			name = line.CodeTarget
+		}
+
+		chunkLines = append(chunkLines, ChunkLine{Code: line.Code, IsChunkRef: line.IsChunkRef})
+	}
+	flush()
+
+	return index
+}
+
+// RootChunks returns the set of names in index that are never referenced by
+// another chunk's <<name>> line — the top-level targets ProcessFiles should
+// tangle to their own output file, as opposed to macro chunks that exist only
+// to be expanded inline wherever they're referenced.
+func RootChunks(index ChunkIndex) map[string]bool {
+	roots := make(map[string]bool, len(index))
+	for name := range index {
+		roots[name] = true
+	}
+
+	for _, chunks := range index {
+		for _, chunk := range chunks {
+			for _, line := range chunk.Lines {
+				if !line.IsChunkRef {
+					continue
+				}
+				if _, ref, ok := parseChunkRef(line.Code); ok {
+					delete(roots, ref)
+				}
+			}
+		}
+	}
+
+	return roots
+}
+
+var rxChunkRef = regexp.MustCompile(`^(\s*)<<(.+)>>\s*$`)
+
+// parseChunkRef recognizes a chunk reference line as emitted by
+// processDirective: optional leading indentation followed by <<name>>.
+func parseChunkRef(line string) (indent, name string, ok bool) {
+	matches := rxChunkRef.FindStringSubmatch(line)
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
+
+// ExpandChunk runs the second tangling pass: it returns the fully expanded
+// body of the chunk named name, recursively substituting every <<ref>> line
+// with the expansion of chunk ref, indented to match the reference site.
+//
+// It returns an error naming the reference chain if name is part of a cycle,
+// or if a reference names a chunk that was never defined.
+func ExpandChunk(index ChunkIndex, name string) ([]string, error) {
+	return expandChunk(index, name, nil)
+}
+
+func expandChunk(index ChunkIndex, name string, chain []string) ([]string, error) {
+	for _, seen := range chain {
+		if seen == name {
+			return nil, fmt.Errorf("mdweb: chunk reference cycle: %s", strings.Join(append(chain, name), " -> "))
+		}
+	}
+
+	chunks, ok := index[name]
+	if !ok {
+		return nil, fmt.Errorf("mdweb: undefined chunk %q, referenced from: %s", name, strings.Join(chain, " -> "))
+	}
+
+	chain = append(chain, name)
+
+	var expanded []string
+	for _, chunk := range chunks {
+		for _, line := range chunk.Lines {
+			if !line.IsChunkRef {
+				expanded = append(expanded, line.Code)
+				continue
+			}
+
+			indent, ref, ok := parseChunkRef(line.Code)
+			if !ok {
+				expanded = append(expanded, line.Code)
+				continue
+			}
+
+			refLines, err := expandChunk(index, ref, chain)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, refLine := range refLines {
+				expanded = append(expanded, indent+refLine)
+			}
+		}
+	}
+
+	return expanded, nil
+}
+
+// ProcessFileChunked processes filename the same way ProcessFile does, but
+// buffers the result to build a ChunkIndex before returning, so callers can
+// tangle the fully expanded output (via ExpandChunk) instead of the single
+// raw pass ProcessFile provides. The returned channel replays the same Lines
+// ProcessFile would have produced.
+func ProcessFileChunked(filename string) (lines <-chan Line, chunks ChunkIndex, err error) {
+	raw, err := ProcessFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buffered []Line
+	for line := range raw {
+		buffered = append(buffered, line)
+	}
+
+	chunks = BuildChunkIndex(buffered)
+
+	out := make(chan Line)
+	go func () {
+		defer close(out)
+		for _, line := range buffered {
+			out <- line
+		}
+	}()
+
+	return out, chunks, nil
+}
@@ -0,0 +1,166 @@
+package mdweb
+
+import "os"
+import "path/filepath"
+import "testing"
+
+func processIndented(t *testing.T, contents string) []Line {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.mdw")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := ProcessFileWithOptions(path, ProcessFileOptions{Syntax: SyntaxIndented})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Line
+	for line := range lines {
+		got = append(got, line)
+	}
+	return got
+}
+
+func TestBuildChunkIndexGroupsByTarget(t *testing.T) {
+	index := BuildChunkIndex([]Line{
+		{Code: "a", CodeTarget: "out.go"},
+		{Code: "b", CodeTarget: "out.go"},
+		{CodeTarget: ""},
+		{Code: "c", CodeTarget: "out.go"},
+	})
+
+	chunks := index["out.go"]
+	if len(chunks) != 2 {
+		t.Fatalf("len(chunks) = %d, want 2 (one per contiguous run)", len(chunks))
+	}
+	if got := chunks[0].Lines; len(got) != 2 || got[0].Code != "a" || got[1].Code != "b" {
+		t.Fatalf("chunks[0].Lines = %#v", got)
+	}
+	if got := chunks[1].Lines; len(got) != 1 || got[0].Code != "c" {
+		t.Fatalf("chunks[1].Lines = %#v", got)
+	}
+}
+
+func TestBuildChunkIndexCarriesIsChunkRef(t *testing.T) {
+	index := BuildChunkIndex([]Line{
+		{Code: "<<body>>", CodeTarget: "main.go", IsChunkRef: true},
+		{Code: "<<PLACEHOLDER>>", CodeTarget: "main.go"},
+	})
+
+	got := index["main.go"][0].Lines
+	if !got[0].IsChunkRef {
+		t.Fatalf("got[0].IsChunkRef = false, want true for an actual reference")
+	}
+	if got[1].IsChunkRef {
+		t.Fatalf("got[1].IsChunkRef = true, want false for code that only looks like a reference")
+	}
+}
+
+func chunkLines(refs ...string) []ChunkLine {
+	lines := make([]ChunkLine, len(refs))
+	for i, ref := range refs {
+		lines[i] = ChunkLine{Code: ref, IsChunkRef: true}
+	}
+	return lines
+}
+
+func TestExpandChunkResolvesNestedReferencesWithIndentation(t *testing.T) {
+	index := ChunkIndex{
+		"main.go": {{Name: "main.go", Lines: []ChunkLine{
+			{Code: "func main() {"},
+			{Code: "\t<<body>>", IsChunkRef: true},
+			{Code: "}"},
+		}}},
+		"body": {{Name: "body", Lines: []ChunkLine{{Code: "fmt.Println(\"hi\")"}}}},
+	}
+
+	got, err := ExpandChunk(index, "main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"func main() {", "\tfmt.Println(\"hi\")", "}"}
+	if len(got) != len(want) {
+		t.Fatalf("ExpandChunk = %#v, want %#v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ExpandChunk[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestExpandChunkIgnoresLookalikeReferencesNotMarkedAsSuch(t *testing.T) {
+	index := ChunkIndex{
+		"main.go": {{Name: "main.go", Lines: []ChunkLine{{Code: "<<PLACEHOLDER>>"}}}},
+	}
+
+	got, err := ExpandChunk(index, "main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"<<PLACEHOLDER>>"}
+	if len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("ExpandChunk = %#v, want %#v", got, want)
+	}
+}
+
+func TestExpandChunkDetectsCycles(t *testing.T) {
+	index := ChunkIndex{
+		"a": {{Name: "a", Lines: chunkLines("<<b>>")}},
+		"b": {{Name: "b", Lines: chunkLines("<<a>>")}},
+	}
+
+	_, err := ExpandChunk(index, "a")
+	if err == nil {
+		t.Fatal("expected a cycle error, got nil")
+	}
+}
+
+func TestExpandChunkReportsUndefinedReference(t *testing.T) {
+	index := ChunkIndex{
+		"a": {{Name: "a", Lines: chunkLines("<<missing>>")}},
+	}
+
+	_, err := ExpandChunk(index, "a")
+	if err == nil {
+		t.Fatal("expected an undefined-chunk error, got nil")
+	}
+}
+
+func TestRootChunksExcludesReferencedMacros(t *testing.T) {
+	index := ChunkIndex{
+		"main.go": {{Name: "main.go", Lines: []ChunkLine{
+			{Code: "func main() {"},
+			{Code: "\t<<greet>>", IsChunkRef: true},
+			{Code: "}"},
+		}}},
+		"greet": {{Name: "greet", Lines: []ChunkLine{{Code: "fmt.Println(\"hi\")"}}}},
+	}
+
+	roots := RootChunks(index)
+	if !roots["main.go"] {
+		t.Fatalf("RootChunks = %#v, want main.go", roots)
+	}
+	if roots["greet"] {
+		t.Fatalf("RootChunks = %#v, want greet excluded (it's only referenced)", roots)
+	}
+}
+
+func TestBoilerplateReferenceStaysHidden(t *testing.T) {
+	src := "\t<<#-->>\n\tpackage main\n\t<<helper>>\n\tmore boilerplate\n"
+
+	for _, line := range processIndented(t, src) {
+		if line.CodeTarget == "" {
+			continue
+		}
+		if line.Text != "" || line.TextTarget != "" {
+			t.Fatalf("boilerplate line leaked into prose: %+v", line)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package codewalk
+
+import "embed"
+
+//go:embed static/codewalk.css static/codewalk.js
+var defaultStatic embed.FS
+
+const defaultTemplate = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{.Doc.Title}}</title>
+	<style>{{.CSS}}</style>
+</head>
+<body>
+	<div class="codewalk">
+		<div class="prose">
+			{{range $i, $region := .Doc.Regions}}
+			<div class="region" data-region="{{$i}}">
+				{{range $region.Lines}}{{.Text}}
+				{{end}}
+			</div>
+			{{end}}
+		</div>
+		<div class="code">
+			{{range $i, $region := .Doc.Regions}}
+			<pre class="region" data-region="{{$i}}" data-target="{{$region.CodeTarget}}">{{range $region.Lines}}{{.Code}}
+{{end}}</pre>
+			{{end}}
+		</div>
+	</div>
+	<script>{{.JS}}</script>
+</body>
+</html>
+`
@@ -0,0 +1,116 @@
+// Package codewalk renders a woven mdweb document as a two-pane HTML view,
+// prose on one side and code on the other, in the style of the old godoc
+// /doc/codewalk/ pages.
+package codewalk
+
+import "io"
+import "io/fs"
+import "html/template"
+
+import "github.com/tokenshift/mdweb"
+
+// A Region groups consecutive Lines that share the same CodeTarget. Regions
+// with an empty CodeTarget are prose-only (no woven code alongside them).
+type Region struct {
+	CodeTarget string
+	Lines []mdweb.Line
+}
+
+// A Document is the input to a Renderer: the regions woven from a single
+// source file, in original order.
+type Document struct {
+	Filename string
+	Title string
+	Regions []Region
+}
+
+// BuildDocument drains lines, grouping consecutive Lines with matching
+// CodeTarget into Regions, and returns the resulting Document.
+func BuildDocument(filename string, lines <-chan mdweb.Line) *Document {
+	doc := &Document {
+		Filename: filename,
+		Title: filename,
+	}
+
+	var current *Region
+
+	for line := range lines {
+		if current != nil && current.CodeTarget == line.CodeTarget {
+			current.Lines = append(current.Lines, line)
+			continue
+		}
+
+		doc.Regions = append(doc.Regions, Region{})
+		current = &doc.Regions[len(doc.Regions)-1]
+		current.CodeTarget = line.CodeTarget
+		current.Lines = append(current.Lines, line)
+	}
+
+	return doc
+}
+
+// RendererOptions customizes the HTML a Renderer produces. A nil field falls
+// back to the default embedded template or static assets.
+type RendererOptions struct {
+	Template *template.Template
+	StaticFS fs.FS
+}
+
+// A Renderer turns a Document into a standalone, two-pane HTML page.
+type Renderer struct {
+	template *template.Template
+	staticFS fs.FS
+}
+
+// NewRenderer builds a Renderer from opts, falling back to the default
+// embedded template and static assets for any field left unset.
+func NewRenderer(opts RendererOptions) (*Renderer, error) {
+	tmpl := opts.Template
+	if tmpl == nil {
+		var err error
+		tmpl, err = template.New("codewalk").Parse(defaultTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	staticFS := opts.StaticFS
+	if staticFS == nil {
+		staticFS = defaultStatic
+	}
+
+	return &Renderer{template: tmpl, staticFS: staticFS}, nil
+}
+
+// RenderHTML writes the two-pane HTML view of doc to w.
+func (r *Renderer) RenderHTML(w io.Writer, doc *Document) error {
+	css, err := readStatic(r.staticFS, "codewalk.css")
+	if err != nil {
+		return err
+	}
+
+	js, err := readStatic(r.staticFS, "codewalk.js")
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		Doc *Document
+		CSS template.CSS
+		JS template.JS
+	}{
+		Doc: doc,
+		CSS: template.CSS(css),
+		JS: template.JS(js),
+	}
+
+	return r.template.Execute(w, data)
+}
+
+func readStatic(staticFS fs.FS, name string) (string, error) {
+	b, err := fs.ReadFile(staticFS, "static/"+name)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,49 @@
+package codewalk
+
+import "bytes"
+import "strings"
+import "testing"
+
+import "github.com/tokenshift/mdweb"
+
+func TestBuildDocumentGroupsConsecutiveLinesIntoRegions(t *testing.T) {
+	ch := make(chan mdweb.Line, 4)
+	ch <- mdweb.Line{Text: "Prose."}
+	ch <- mdweb.Line{Code: "a", CodeTarget: "main.go"}
+	ch <- mdweb.Line{Code: "b", CodeTarget: "main.go"}
+	ch <- mdweb.Line{Text: "More prose."}
+	close(ch)
+
+	doc := BuildDocument("test.md", ch)
+
+	if len(doc.Regions) != 3 {
+		t.Fatalf("len(Regions) = %d, want 3 (prose, code, prose)", len(doc.Regions))
+	}
+	if doc.Regions[1].CodeTarget != "main.go" || len(doc.Regions[1].Lines) != 2 {
+		t.Fatalf("Regions[1] = %+v, want a 2-line main.go region", doc.Regions[1])
+	}
+}
+
+func TestRenderHTMLIncludesDocTitleAndStaticAssets(t *testing.T) {
+	r, err := NewRenderer(RendererOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := &Document{Filename: "test.md", Title: "test.md"}
+
+	var out bytes.Buffer
+	if err := r.RenderHTML(&out, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "test.md") {
+		t.Fatalf("rendered output missing the document title: %s", out.String())
+	}
+	if !strings.Contains(out.String(), ".codewalk .region.highlight") {
+		t.Fatalf("rendered output missing the embedded CSS: %s", out.String())
+	}
+	if !strings.Contains(out.String(), "addEventListener") {
+		t.Fatalf("rendered output missing the embedded JS: %s", out.String())
+	}
+}
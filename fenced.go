@@ -0,0 +1,119 @@
+package mdweb
+
+import "bufio"
+import "regexp"
+import "strings"
+
+// Syntax selects which code-region convention ProcessFileWithOptions parses.
+type Syntax int
+
+const (
+	// SyntaxIndented recognizes code by leading tab or four-space indent,
+	// with <<target>> directives naming the region. This is the original
+	// mdweb convention.
+	SyntaxIndented Syntax = iota
+
+	// SyntaxFenced recognizes code delimited by ``` or ~~~ fences, as in
+	// CommonMark, with the target and attributes given in the info string.
+	SyntaxFenced
+)
+
+// ProcessFileOptions configures ProcessFileWithOptions.
+type ProcessFileOptions struct {
+	Syntax Syntax
+}
+
+var rxFenceOpen = regexp.MustCompile("^(```+|~~~+)\\s*(.*)$")
+
+// isFenceClose reports whether line closes a fence opened with fenceChar
+// repeated fenceLen times: a line of only that character, at least as long.
+func isFenceClose(fenceChar byte, fenceLen int, line string) bool {
+	trimmed := strings.TrimRight(strings.TrimSpace(line), " \t")
+	if len(trimmed) < fenceLen {
+		return false
+	}
+
+	for i := 0; i < len(trimmed); i++ {
+		if trimmed[i] != fenceChar {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseInfoString parses a fence info string of the form
+// "lang [target=path/to/out.go] [{.boilerplate}]", returning the declared
+// language (if any), the named target (if any) and the attribute name (if
+// any, without the braces/dot).
+func parseInfoString(info string) (lang, target, attr string) {
+	for _, field := range strings.Fields(info) {
+		switch {
+		case strings.HasPrefix(field, "target="):
+			target = strings.TrimPrefix(field, "target=")
+		case strings.HasPrefix(field, "{.") && strings.HasSuffix(field, "}"):
+			attr = field[2 : len(field)-1]
+		default:
+			if lang == "" {
+				lang = field
+			}
+		}
+	}
+
+	return
+}
+
+// runFenced drains scanner using the fenced-code convention, emitting Lines
+// to data.Output the same way the indented state machine does.
+func runFenced(data StateData, scanner *bufio.Scanner) {
+	inFence := false
+	var fenceChar byte
+	var fenceLen int
+	var attr string
+	var target string
+	var lang string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !inFence {
+			if matches := rxFenceOpen.FindStringSubmatch(line); matches != nil {
+				inFence = true
+				fenceChar = matches[1][0]
+				fenceLen = len(matches[1])
+
+				var t string
+				lang, t, attr = parseInfoString(matches[2])
+				if t != "" {
+					target = t
+				} else {
+					target = data.DefaultCodeOutput
+				}
+			}
+
+			data.Output <- Line{Text: line, TextTarget: data.DefaultTextOutput}
+			continue
+		}
+
+		if isFenceClose(fenceChar, fenceLen, line) {
+			inFence = false
+			data.Output <- Line{Text: line, TextTarget: data.DefaultTextOutput}
+			continue
+		}
+
+		switch attr {
+		case "boilerplate":
+			data.Output <- Line{Code: line, CodeTarget: target, Lang: lang}
+		case "example":
+			data.Output <- Line{Text: line, TextTarget: data.DefaultTextOutput}
+		default:
+			data.Output <- Line {
+				Code: line,
+				CodeTarget: target,
+				Lang: lang,
+				Text: line,
+				TextTarget: data.DefaultTextOutput,
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package mdweb
+
+import "os"
+import "path/filepath"
+import "testing"
+
+func processFenced(t *testing.T, contents string) []Line {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.mdw")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := ProcessFileWithOptions(path, ProcessFileOptions{Syntax: SyntaxFenced})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Line
+	for line := range lines {
+		got = append(got, line)
+	}
+	return got
+}
+
+func codeLines(lines []Line) (code []string) {
+	for _, line := range lines {
+		if line.CodeTarget != "" {
+			code = append(code, line.Code)
+		}
+	}
+	return
+}
+
+func TestFencedBacktickTarget(t *testing.T) {
+	lines := processFenced(t, "Prose.\n\n```go target=main.go\nfmt.Println(\"hi\")\n```\n")
+
+	got := codeLines(lines)
+	want := []string{`fmt.Println("hi")`}
+
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Fatalf("code lines = %#v, want %#v", got, want)
+	}
+
+	for _, line := range lines {
+		if line.CodeTarget != "" && line.CodeTarget != "main.go" {
+			t.Fatalf("CodeTarget = %q, want %q", line.CodeTarget, "main.go")
+		}
+		if line.CodeTarget != "" && line.Lang != "go" {
+			t.Fatalf("Lang = %q, want %q", line.Lang, "go")
+		}
+	}
+}
+
+func TestFencedTildeVariant(t *testing.T) {
+	lines := processFenced(t, "Prose.\n\n~~~go\nfmt.Println(\"hi\")\n~~~\n")
+
+	got := codeLines(lines)
+	if len(got) != 1 || got[0] != `fmt.Println("hi")` {
+		t.Fatalf("code lines = %#v", got)
+	}
+}
+
+func TestFencedNested(t *testing.T) {
+	// An outer fence of four backticks can safely contain a three-backtick
+	// run as literal text, since CommonMark only closes a fence on a run of
+	// the same character at least as long as the one that opened it.
+	src := "Prose.\n\n````go target=outer.go\n" +
+		"```\n" +
+		"fmt.Println(\"```\")\n" +
+		"```\n" +
+		"````\n"
+
+	lines := processFenced(t, src)
+
+	got := codeLines(lines)
+	want := []string{"```", `fmt.Println("` + "```" + `")`, "```"}
+
+	if len(got) != len(want) {
+		t.Fatalf("code lines = %#v, want %#v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("code lines = %#v, want %#v", got, want)
+		}
+	}
+}
+
+func TestFencedBoilerplateAndExampleAttributes(t *testing.T) {
+	src := "Prose.\n\n```go target=out.go {.boilerplate}\n// generated\n```\n\n" +
+		"```go {.example}\nfmt.Println(\"not tangled\")\n```\n"
+
+	lines := processFenced(t, src)
+
+	var sawBoilerplate, sawExample bool
+	for _, line := range lines {
+		if line.CodeTarget == "out.go" {
+			sawBoilerplate = true
+			if line.Text != "" {
+				t.Fatalf("boilerplate line leaked into prose: %q", line.Text)
+			}
+		}
+		if line.CodeTarget == "" && line.Text == `fmt.Println("not tangled")` {
+			sawExample = true
+		}
+	}
+
+	if !sawBoilerplate {
+		t.Fatal("expected a boilerplate code line for out.go")
+	}
+	if !sawExample {
+		t.Fatal("expected the example block's text to appear in prose, untangled")
+	}
+}
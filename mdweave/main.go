@@ -1,40 +1,80 @@
 package main
 
+import "flag"
 import "fmt"
+import "io"
 import "os"
 import "path/filepath"
 
 import "github.com/tokenshift/mdweb"
+import "github.com/tokenshift/mdweb/codewalk"
+
+func init() {
+	mdweb.RegisterWeaver("codewalk", codewalkWeaver{})
+}
 
 func main() {
-	// Each argument is treated as a glob specification.
-	for _, arg := range os.Args[1:] {
+	format := flag.String("format", "text", "output format: text, html, or codewalk")
+	flag.Parse()
+
+	weaver, ok := mdweb.GetWeaver(*format)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "mdweave: unknown format %q\n", *format)
+		os.Exit(1)
+	}
+
+	ext := extensionFor(*format)
+
+	// Each remaining argument is treated as a glob specification.
+	for _, arg := range flag.Args() {
 		files, _ := filepath.Glob(arg)
 		for _, file := range files {
-			target, lines, err := mdweb.ProcessFile(file)
-			if err != nil {
+			if err := weaveFile(weaver, file, ext); err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
+		}
+	}
+}
 
-			file, err := os.Create(target)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
+func extensionFor(format string) string {
+	switch format {
+	case "html", "codewalk":
+		return ".html"
+	default:
+		return ".md"
+	}
+}
 
-			fmt.Println("Writing text to", target)
-			for line := range lines {
-				if line.TextTarget == "" {
-					continue
-				}
+func weaveFile(weaver mdweb.Weaver, file, ext string) error {
+	lines, err := mdweb.ProcessFile(file)
+	if err != nil {
+		return err
+	}
 
-				if line.CodeTarget != "" {
-					fmt.Fprint(file, "\t")
-				}
+	target := file[0:len(file)-len(filepath.Ext(file))] + ext
 
-				fmt.Fprint(file, line.Text)
-			}
-		}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
 	}
+	defer out.Close()
+
+	fmt.Println("Writing text to", target)
+	return weaver.Weave(out, file, lines)
+}
+
+// codewalkWeaver adapts codewalk.Renderer to the mdweb.Weaver interface. It
+// lives here rather than in the mdweb package itself to avoid an import
+// cycle (codewalk already imports mdweb).
+type codewalkWeaver struct{}
+
+func (codewalkWeaver) Weave(w io.Writer, filename string, lines <-chan mdweb.Line) error {
+	renderer, err := codewalk.NewRenderer(codewalk.RendererOptions{})
+	if err != nil {
+		return err
+	}
+
+	doc := codewalk.BuildDocument(filename, lines)
+	return renderer.RenderHTML(w, doc)
 }
@@ -0,0 +1,47 @@
+package main
+
+import "fmt"
+import "os"
+import "path/filepath"
+
+import "github.com/tokenshift/mdweb"
+import "github.com/tokenshift/mdweb/codewalk"
+
+func main() {
+	renderer, err := codewalk.NewRenderer(codewalk.RendererOptions{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Each argument is treated as a glob specification.
+	for _, arg := range os.Args[1:] {
+		files, _ := filepath.Glob(arg)
+		for _, file := range files {
+			if err := renderFile(renderer, file); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+func renderFile(renderer *codewalk.Renderer, file string) error {
+	lines, err := mdweb.ProcessFile(file)
+	if err != nil {
+		return err
+	}
+
+	doc := codewalk.BuildDocument(file, lines)
+
+	target := file[0:len(file)-len(filepath.Ext(file))] + ".html"
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	fmt.Println("Writing codewalk to", target)
+	return renderer.RenderHTML(out, doc)
+}
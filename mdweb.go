@@ -1,17 +1,38 @@
 package mdweb
 
 import "bufio"
+import "bytes"
 import "fmt"
+import "io"
+import "io/fs"
 import "os"
+import "path"
 import "path/filepath"
 import "regexp"
 import "strings"
+import "sync"
 
 type Line struct {
 	Code string
 	CodeTarget string
+	// Lang is the fence info string's language, e.g. "go" in ```go. Only
+	// set by SyntaxFenced; SyntaxIndented has no way to declare it.
+	Lang string
+	// IsChunkRef is true when Code is a <<name>> chunk reference rather
+	// than ordinary code text. Only SyntaxIndented's processDirective sets
+	// it, for a <<name>> directive seen after code has started in the
+	// current region; SyntaxFenced has no reference convention, so a line
+	// that merely looks like <<name>> (e.g. a template placeholder inside a
+	// fenced block) is left as plain code.
+	IsChunkRef bool
 	Text string
 	TextTarget string
+	// Err is set, with every other field left zero, on the final Line a
+	// channel from ProcessReader (or ProcessFile/ProcessFileWithOptions/
+	// ProcessFS, which wrap it) ever sends, if the underlying scan failed.
+	// A caller that only cares about I/O succeeding can check the last
+	// Line it receives; one that wants to fail fast can check every Line.
+	Err error
 }
 
 // Removes a single extension from the filename.
@@ -38,6 +59,16 @@ type StateData struct {
 	DefaultCodeOutput string
 	DefaultTextOutput string
 	CurrentTarget string
+	// CodeStarted is true once a genuine code line (not a directive) has
+	// been emitted for the region CurrentTarget names. A <<name>> seen
+	// before that point declares the region's target; one seen after
+	// that point is a reference to chunk name, expanded by ExpandChunk.
+	CodeStarted bool
+	// Boilerplate is true between a <<#-->> directive and the line that
+	// ends stateBoilerplate, so a chunk reference encountered mid-block
+	// resumes stateBoilerplate (and stays hidden) rather than falling
+	// through to stateCode.
+	Boilerplate bool
 	Output chan<- Line
 }
 
@@ -52,6 +83,7 @@ func stateCode(data StateData, inputLine string) BoundState {
 			Text: inputLine,
 			TextTarget: data.DefaultTextOutput,
 		}
+		data.CodeStarted = true
 		return partialState(stateCode, data)
 	} else {
 		data.Output <- Line {
@@ -60,6 +92,7 @@ func stateCode(data StateData, inputLine string) BoundState {
 			Text: inputLine,
 			TextTarget: data.DefaultTextOutput,
 		}
+		data.CodeStarted = false
 		return partialState(stateText, data)
 	}
 }
@@ -75,6 +108,7 @@ func stateBoilerplate(data StateData, inputLine string) BoundState {
 			Text: "",
 			TextTarget: "",
 		}
+		data.CodeStarted = true
 		return partialState(stateBoilerplate, data)
 	} else {
 		data.Output <- Line {
@@ -83,6 +117,8 @@ func stateBoilerplate(data StateData, inputLine string) BoundState {
 			Text: inputLine,
 			TextTarget: data.DefaultTextOutput,
 		}
+		data.CodeStarted = false
+		data.Boilerplate = false
 		return partialState(stateText, data)
 	}
 }
@@ -120,6 +156,7 @@ func stateText(data StateData, inputLine string) BoundState {
 			Text: inputLine,
 			TextTarget: data.DefaultTextOutput,
 		}
+		data.CodeStarted = true
 		return partialState(stateCode, data)
 	} else {
 		data.Output <- Line {
@@ -132,7 +169,7 @@ func stateText(data StateData, inputLine string) BoundState {
 	}
 }
 
-var rxDirective = regexp.MustCompile("^<<(.*)>>\\s*$")
+var rxDirective = regexp.MustCompile("^(\\s*)<<(.*)>>\\s*$")
 
 func unindent(line string) (string, bool) {
 	if strings.HasPrefix(line, "\t") {
@@ -144,150 +181,358 @@ func unindent(line string) (string, bool) {
 	}
 }
 
-func parseDirective(line string) (directive string, ok bool) {
+// parseDirective recognizes a <<name>> directive line, returning any
+// whitespace preceding it (preserved when name turns out to be a chunk
+// reference, so expansion stays nested) and the name itself.
+func parseDirective(line string) (indent, directive string, ok bool) {
 	line, isIndented := unindent(line)
 	if !isIndented {
-		return "", false
+		return "", "", false
 	}
 
 	matches := rxDirective.FindStringSubmatch(line)
 	if matches == nil {
-		return "", false
+		return "", "", false
 	}
 
-	return strings.TrimSpace(matches[1]), true
+	return matches[1], strings.TrimSpace(matches[2]), true
 }
 
 func partialState(s State, data StateData) BoundState {
 	return func(inputLine string) BoundState {
-		if directive, isDirective := parseDirective(inputLine); isDirective {
-			return processDirective(data, directive)
+		if indent, directive, isDirective := parseDirective(inputLine); isDirective {
+			return processDirective(data, indent, directive)
 		} else {
 			return s(data, inputLine)
 		}
 	}
 }
 
-func processDirective(data StateData, directive string) BoundState {
+func processDirective(data StateData, indent, directive string) BoundState {
 	switch directive {
 
 	case "!--":
 		return partialState(stateExample, data)
 
 	case "#--":
+		data.CodeStarted = false
+		data.Boilerplate = true
 		return partialState(stateBoilerplate, data)
 
 	default:
+		// Whichever of stateCode/stateBoilerplate was interrupted by this
+		// directive is the one to resume; a reference or target
+		// declaration seen mid-boilerplate must stay hidden.
+		next := stateCode
+		if data.Boilerplate {
+			next = stateBoilerplate
+		}
+
+		if data.CodeStarted && directive != "" {
+			ref := indent + "<<" + directive + ">>"
+			line := Line {
+				Code: ref,
+				CodeTarget: data.CurrentTarget,
+				IsChunkRef: true,
+			}
+			if !data.Boilerplate {
+				line.Text = ref
+				line.TextTarget = data.DefaultTextOutput
+			}
+			data.Output <- line
+			return partialState(next, data)
+		}
+
 		if directive == "" {
 			data.CurrentTarget = data.DefaultCodeOutput
 		} else {
 			data.CurrentTarget = directive
 		}
-		return partialState(stateCode, data)
+		data.CodeStarted = false
+		return partialState(next, data)
 	}
 }
 
-func ProcessFile(filename string) (lines <-chan Line, err error) {
+// ProcessOptions configures ProcessReader: since a bare io.Reader carries no
+// name of its own, Filename supplies the name ProcessReader would otherwise
+// derive from the path, for computing default code/text targets.
+type ProcessOptions struct {
+	Filename string
+	Syntax Syntax
+}
+
+// ProcessReader processes r directly, without touching the filesystem. This
+// is the common implementation behind ProcessFile, ProcessFileWithOptions
+// and ProcessFS; it's also useful on its own for sources that were never
+// files, e.g. an in-memory template or a network response.
+//
+// If r also implements io.Closer (as *os.File and fs.File do), it is closed
+// once the returned channel is drained.
+func ProcessReader(r io.Reader, opts ProcessOptions) (lines <-chan Line, err error) {
 	out := make(chan Line)
 
-	defaultCodeOutput := removeExtension(filename)
+	defaultCodeOutput := removeExtension(opts.Filename)
 	data := StateData {
 		DefaultCodeOutput: defaultCodeOutput,
-		DefaultTextOutput: removeExtensions(filename) + ".md",
+		DefaultTextOutput: removeExtensions(opts.Filename) + ".md",
 		CurrentTarget: defaultCodeOutput,
 		Output: out,
 	}
 
-	currentState := partialState(stateText, data)
-
-	input, err := os.Open(filename)
-	if err != nil {
-		return
-	}
-
-	scanner := bufio.NewScanner(input)
+	scanner := bufio.NewScanner(r)
 
 	go func () {
 		defer close(data.Output)
-		defer input.Close()
+		if closer, ok := r.(io.Closer); ok {
+			defer closer.Close()
+		}
 
-		for scanner.Scan() {
-			currentState = currentState(scanner.Text())
+		if opts.Syntax == SyntaxFenced {
+			runFenced(data, scanner)
+		} else {
+			currentState := partialState(stateText, data)
+			for scanner.Scan() {
+				currentState = currentState(scanner.Text())
+			}
 		}
 
 		if err := scanner.Err(); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			data.Output <- Line{Err: err}
 		}
 	}()
 
 	return out, nil
 }
 
-func ProcessFiles(writeCode, writeText bool, patterns ...string) {
-	outputFiles := make(map[string]*os.File)
+// ProcessFile processes filename using ProcessFileOptions auto-detected from
+// its extension: SyntaxFenced for .md, SyntaxIndented otherwise (including
+// .mdw, the traditional mdweb extension).
+func ProcessFile(filename string) (lines <-chan Line, err error) {
+	return ProcessFileWithOptions(filename, ProcessFileOptions{Syntax: detectSyntax(filename)})
+}
+
+// ProcessFileWithOptions processes filename the same way ProcessFile does,
+// but lets the caller pick the parser mode explicitly rather than relying on
+// the extension-based default.
+func ProcessFileWithOptions(filename string, opts ProcessFileOptions) (lines <-chan Line, err error) {
+	input, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+
+	return ProcessReader(input, ProcessOptions{Filename: filename, Syntax: opts.Syntax})
+}
+
+// ProcessFS processes filename read from fsys instead of the host
+// filesystem, e.g. an embed.FS, an os.DirFS rooted elsewhere, or an fstest.MapFS
+// in a test. Syntax is auto-detected from filename the same way ProcessFile
+// does.
+func ProcessFS(fsys fs.FS, filename string) (lines <-chan Line, err error) {
+	input, err := fsys.Open(filename)
+	if err != nil {
+		return
+	}
+
+	return ProcessReader(input, ProcessOptions{Filename: filename, Syntax: detectSyntax(filename)})
+}
+
+// detectSyntax picks a parser mode based on filename's extension.
+func detectSyntax(filename string) Syntax {
+	if filepath.Ext(filename) == ".md" {
+		return SyntaxFenced
+	}
+	return SyntaxIndented
+}
+
+// An OutputSink creates the files ProcessFiles tangles code and text into.
+// OSOutputSink writes to the host filesystem; MemOutputSink keeps everything
+// in memory, e.g. for tests or for building a tree a caller commits to disk
+// atomically.
+type OutputSink interface {
+	Create(path string) (io.WriteCloser, error)
+}
+
+// OSOutputSink creates real files on the host filesystem via os.Create.
+type OSOutputSink struct{}
+
+func (OSOutputSink) Create(path string) (io.WriteCloser, error) {
+	return os.Create(path)
+}
+
+// MemOutputSink collects output in memory, keyed by the path it would have
+// been written to. It is safe for concurrent use.
+type MemOutputSink struct {
+	mu sync.Mutex
+	Files map[string]*bytes.Buffer
+}
+
+// NewMemOutputSink returns an empty MemOutputSink.
+func NewMemOutputSink() *MemOutputSink {
+	return &MemOutputSink{Files: make(map[string]*bytes.Buffer)}
+}
+
+func (s *MemOutputSink) Create(path string) (io.WriteCloser, error) {
+	buf := &bytes.Buffer{}
+
+	s.mu.Lock()
+	s.Files[path] = buf
+	s.mu.Unlock()
+
+	return nopWriteCloser{buf}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// WeaveConfig configures ProcessFiles: FS is where the input patterns are
+// resolved and read from, Output is where tangled code and text are written.
+//
+// WriteCode writes each target's fully expanded code (chunk references
+// resolved via ExpandChunk). WriteChunks dumps each target's raw, unexpanded
+// chunk definitions to <target>.chunk, for debugging the chunk table itself;
+// it works independently of WriteCode, and the two can be set together.
+type WeaveConfig struct {
+	FS fs.FS
+	Output OutputSink
+	WriteCode bool
+	WriteText bool
+	WriteChunks bool
+	Patterns []string
+}
+
+// ProcessFiles tangles code and/or text from every file matched by
+// config.Patterns in config.FS, writing the results through config.Output.
+func ProcessFiles(config WeaveConfig) error {
+	outputFiles := make(map[string]io.WriteCloser)
+	defer func () {
+		for _, out := range outputFiles {
+			out.Close()
+		}
+	}()
+
+	for _, pattern := range config.Patterns {
+		files, err := fs.Glob(config.FS, pattern)
+		if err != nil {
+			return err
+		}
 
-	for _, pattern := range patterns {
-		files, _ := filepath.Glob(pattern)
 		for _, file := range files {
-			lines, err := ProcessFile(file)
+			if err := processOneFile(config, file, outputFiles); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func processOneFile(config WeaveConfig, file string, outputFiles map[string]io.WriteCloser) error {
+	lines, err := ProcessFS(config.FS, file)
+	if err != nil {
+		return err
+	}
 
+	var buffered []Line
+	for line := range lines {
+		buffered = append(buffered, line)
+
+		if config.WriteText && line.TextTarget != "" {
+			textTarget := resolveTargetPath(file, line.TextTarget)
+			out, err := getOutputFile(config.Output, outputFiles, textTarget, "Writing documentation to")
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				return err
 			}
 
-			for line := range lines {
-				if writeCode && line.CodeTarget != "" {
-					absCodeTarget := getAbsTargetPath(file, line.CodeTarget)
-					out, ok := outputFiles[absCodeTarget]
-					if !ok {
-						out, err = os.Create(absCodeTarget)
-						if err != nil {
-							fmt.Fprintln(os.Stderr, err)
-							os.Exit(1)
-						}
-						defer out.Close()
-						fmt.Println("Writing code to", absCodeTarget)
-						outputFiles[absCodeTarget] = out
-					}
-
-					fmt.Fprintln(out, line.Code)
-				}
+			fmt.Fprintln(out, line.Text)
+		}
+	}
+
+	if !config.WriteCode && !config.WriteChunks {
+		return nil
+	}
 
-				if writeText && line.TextTarget != "" {
-					absTextTarget := getAbsTargetPath(file, line.TextTarget)
-					out, ok := outputFiles[absTextTarget]
-					if !ok {
-						out, err = os.Create(absTextTarget)
-						if err != nil {
-							fmt.Fprintln(os.Stderr, err)
-							os.Exit(1)
-						}
-						defer out.Close()
-						fmt.Println("Writing documentation to", absTextTarget)
-						outputFiles[absTextTarget] = out
-					}
-
-					fmt.Fprintln(out, line.Text)
+	index := BuildChunkIndex(buffered)
+
+	if config.WriteChunks {
+		for name, chunks := range index {
+			chunkTarget := resolveTargetPath(file, name) + ".chunk"
+			out, err := getOutputFile(config.Output, outputFiles, chunkTarget, "Writing chunk definitions to")
+			if err != nil {
+				return err
+			}
+
+			for i, chunk := range chunks {
+				fmt.Fprintf(out, "-- %s, definition %d --\n", chunk.Name, i+1)
+				for _, chunkLine := range chunk.Lines {
+					fmt.Fprintln(out, chunkLine.Code)
 				}
 			}
 		}
 	}
-}
 
-func getAbsTargetPath(source, targetPath string) string {
-	if filepath.IsAbs(targetPath) {
-		return targetPath
+	if config.WriteCode {
+		roots := RootChunks(index)
+
+		// Expand every chunk, root or not, so a cycle or undefined
+		// reference is always reported even if nothing outside its own
+		// reference cycle points at it; only a root chunk (one no other
+		// chunk references — a macro body, in classic literate style,
+		// belongs inline at its reference sites instead) gets its own
+		// output file.
+		for name := range index {
+			expanded, err := ExpandChunk(index, name)
+			if err != nil {
+				return err
+			}
+
+			if !roots[name] {
+				continue
+			}
+
+			codeTarget := resolveTargetPath(file, name)
+			out, err := getOutputFile(config.Output, outputFiles, codeTarget, "Writing code to")
+			if err != nil {
+				return err
+			}
+
+			for _, codeLine := range expanded {
+				fmt.Fprintln(out, codeLine)
+			}
+		}
 	}
 
-	sourceDir := filepath.Dir(source)
-	path := filepath.Join(sourceDir, targetPath)
-	abs, err := filepath.Abs(path)
+	return nil
+}
+
+func getOutputFile(sink OutputSink, outputFiles map[string]io.WriteCloser, path, logPrefix string) (io.WriteCloser, error) {
+	if out, ok := outputFiles[path]; ok {
+		return out, nil
+	}
 
+	out, err := sink.Create(path)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	fmt.Println(logPrefix, path)
+	outputFiles[path] = out
+	return out, nil
+}
+
+// resolveTargetPath resolves targetPath relative to source, both named
+// within config.FS's own path space (always slash-separated, per io/fs) —
+// never against the host process's working directory. An os.DirFS and an
+// embed.FS are both valid config.FS values and neither has a real directory
+// the host CWD could meaningfully anchor to.
+func resolveTargetPath(source, targetPath string) string {
+	if strings.HasPrefix(targetPath, "/") {
+		return targetPath
 	}
 
-	return abs
+	return path.Join(path.Dir(source), targetPath)
 }
@@ -0,0 +1,331 @@
+package mdweb
+
+import "fmt"
+import "net/http"
+import "path/filepath"
+import "strings"
+import "sync"
+
+import "github.com/fsnotify/fsnotify"
+
+// ServeOptions customizes the behavior of Serve. The zero value uses sane
+// defaults: the built-in page template and no request logging.
+type ServeOptions struct {
+	// Logf, if set, receives a line for every served request.
+	Logf func(format string, args ...interface{})
+}
+
+// Serve starts an HTTP server on addr that renders each file matched by
+// patterns at /<basename>, and exposes the tangled code and text outputs at
+// /raw/<target>. Input files are watched for changes; browsers connected to
+// /_events receive a reload event over SSE whenever a watched file changes,
+// so edits show up without re-running mdweave or mdtangle by hand.
+//
+// Serve blocks until the HTTP server stops, which normally means it never
+// returns except on error.
+func Serve(addr string, patterns []string, opts ServeOptions) error {
+	files, err := expandPatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("mdweb: no files matched by %v", patterns)
+	}
+
+	srv := &server{
+		opts:    opts,
+		docs:    make(map[string]*servedDoc),
+		watched: make(map[string]bool),
+		events:  newEventHub(),
+		weaver:  NewHTMLWeaver(HTMLWeaverOptions{}),
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	watchedDirs := make(map[string]bool)
+
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+
+		srv.watched[abs] = true
+
+		if err := srv.load(abs); err != nil {
+			return err
+		}
+
+		// Watching the containing directory, rather than the file itself,
+		// survives an atomic save (write-to-temp then rename-over-original,
+		// as vim and most editors do): fsnotify ties a watch to the inode
+		// it resolved at Add time, so a file-level watch goes dead the
+		// instant that inode is replaced. A directory-level watch has no
+		// such inode to lose.
+		dir := filepath.Dir(abs)
+		if !watchedDirs[dir] {
+			if err := watcher.Add(dir); err != nil {
+				return err
+			}
+			watchedDirs[dir] = true
+		}
+	}
+
+	go srv.watchLoop(watcher)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_events", srv.handleEvents)
+	mux.HandleFunc("/raw/", srv.handleRaw)
+	mux.HandleFunc("/", srv.handleDoc)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.logf("mdweb: %s %s", r.Method, r.URL.Path)
+		mux.ServeHTTP(w, r)
+	})
+
+	return http.ListenAndServe(addr, handler)
+}
+
+func expandPatterns(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// servedDoc is the buffered result of processing a single input file: the
+// rendered page plus every text target and every root chunk's fully expanded
+// code (as mdtangle would write it), so repeated requests don't re-run the
+// state machine.
+type servedDoc struct {
+	basename string
+	rendered string
+	targets  map[string]string
+}
+
+type server struct {
+	opts ServeOptions
+
+	watched map[string]bool // absolute paths of files Serve was asked to watch
+
+	mu   sync.RWMutex
+	docs map[string]*servedDoc // keyed by absolute source file path
+
+	events *eventHub
+	weaver *HTMLWeaver
+}
+
+// load processes file and stores the result, replacing any previous version.
+func (s *server) load(file string) error {
+	lines, err := ProcessFile(file)
+	if err != nil {
+		return err
+	}
+
+	doc := &servedDoc{
+		basename: removeExtensions(filepath.Base(file)),
+		targets:  make(map[string]string),
+	}
+
+	var buffered []Line
+	for line := range lines {
+		buffered = append(buffered, line)
+
+		if line.TextTarget != "" {
+			doc.targets[line.TextTarget] += line.Text + "\n"
+		}
+	}
+
+	index := BuildChunkIndex(buffered)
+	for name := range RootChunks(index) {
+		expanded, err := ExpandChunk(index, name)
+		if err != nil {
+			return err
+		}
+		doc.targets[name] = strings.Join(expanded, "\n") + "\n"
+	}
+
+	// Rendered through the same HTMLWeaver mdweave's -format html uses, so
+	// the live page matches the static one: prose through goldmark, code
+	// highlighted via Highlighter, grouped by contiguous region rather than
+	// escaped line by line.
+	body, err := s.weaver.renderBody(buffered)
+	if err != nil {
+		return err
+	}
+	doc.rendered = reloadScript + string(body)
+
+	s.mu.Lock()
+	s.docs[file] = doc
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *server) watchLoop(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			// The watch is on event.Name's containing directory, so this
+			// fires for every file in it; only act on the ones Serve was
+			// asked to watch.
+			abs, err := filepath.Abs(event.Name)
+			if err != nil || !s.watched[abs] {
+				continue
+			}
+
+			// Remove/Rename show up for the delete half of an atomic save;
+			// the Create that follows (for the renamed-in replacement) is
+			// what actually triggers the reload, so there's nothing to do
+			// here but nothing to re-Add either, since the directory watch
+			// already covers it.
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+
+			if err := s.load(abs); err != nil {
+				s.logf("mdweb: reload %s: %v", abs, err)
+				continue
+			}
+			s.logf("mdweb: reloaded %s", abs)
+			s.events.broadcast("reload")
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			s.logf("mdweb: watch error: %v", err)
+		}
+	}
+}
+
+func (s *server) handleDoc(w http.ResponseWriter, r *http.Request) {
+	basename := strings.TrimPrefix(r.URL.Path, "/")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, doc := range s.docs {
+		if doc.basename == basename {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, doc.rendered)
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+func (s *server) handleRaw(w http.ResponseWriter, r *http.Request) {
+	target := strings.TrimPrefix(r.URL.Path, "/raw/")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, doc := range s.docs {
+		if content, ok := doc.targets[target]; ok {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, content)
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	for {
+		select {
+		case msg := <-ch:
+			fmt.Fprintf(w, "data: %s\n\n", msg)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *server) logf(format string, args ...interface{}) {
+	if s.opts.Logf != nil {
+		s.opts.Logf(format, args...)
+	}
+}
+
+// eventHub fans out SSE messages to every subscribed browser.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan string]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan string]bool)}
+}
+
+func (h *eventHub) subscribe() chan string {
+	ch := make(chan string, 1)
+	h.mu.Lock()
+	h.subs[ch] = true
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan string) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) broadcast(msg string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// reloadScript is injected into every rendered page. It reconnects to
+// /_events on disconnect and reloads the page on any message.
+const reloadScript = `<script>
+(function () {
+	function connect() {
+		var es = new EventSource("/_events");
+		es.onmessage = function () { location.reload(); };
+		es.onerror = function () {
+			es.close();
+			setTimeout(connect, 1000);
+		};
+	}
+	connect();
+})();
+</script>
+`
@@ -0,0 +1,83 @@
+package mdweb
+
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+import "github.com/fsnotify/fsnotify"
+
+func TestServerLoadRendersDocAndTargets(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.mdw")
+	if err := os.WriteFile(file, []byte("Prose.\n\n\t<<main.go>>\n\tfmt.Println(\"hi\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &server{
+		docs:    make(map[string]*servedDoc),
+		watched: make(map[string]bool),
+		events:  newEventHub(),
+		weaver:  NewHTMLWeaver(HTMLWeaverOptions{}),
+	}
+	if err := srv.load(file); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := srv.docs[file]
+	if doc == nil {
+		t.Fatal("load did not store a servedDoc")
+	}
+	if got := doc.targets["main.go"]; got != "fmt.Println(\"hi\")\n" {
+		t.Fatalf("targets[main.go] = %q, want %q", got, "fmt.Println(\"hi\")\n")
+	}
+	if want := "<pre class=\"mdweb-code\" data-target=\"main.go\">"; !strings.Contains(doc.rendered, want) {
+		t.Fatalf("rendered = %q, want it to contain %q (woven via HTMLWeaver, not per-line escaping)", doc.rendered, want)
+	}
+	if want := "<p>Prose.</p>"; !strings.Contains(doc.rendered, want) {
+		t.Fatalf("rendered = %q, want goldmark-rendered prose %q", doc.rendered, want)
+	}
+}
+
+// TestWatchLoopSurvivesAtomicSave reproduces an atomic save (write-to-temp,
+// rename-over-original): fsnotify reports Remove/Rename for the old inode
+// followed by Create for the replacement. watchLoop must still reload and
+// broadcast on the Create, rather than treating the watch as dead.
+func TestWatchLoopSurvivesAtomicSave(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.mdw")
+	if err := os.WriteFile(file, []byte("Prose.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &server{
+		docs:    make(map[string]*servedDoc),
+		watched: map[string]bool{abs: true},
+		events:  newEventHub(),
+		weaver:  NewHTMLWeaver(HTMLWeaverOptions{}),
+	}
+	if err := srv.load(abs); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := &fsnotify.Watcher{Events: make(chan fsnotify.Event), Errors: make(chan error)}
+	sub := srv.events.subscribe()
+	defer srv.events.unsubscribe(sub)
+
+	go srv.watchLoop(watcher)
+
+	watcher.Events <- fsnotify.Event{Name: abs, Op: fsnotify.Remove}
+	watcher.Events <- fsnotify.Event{Name: abs, Op: fsnotify.Create}
+
+	select {
+	case <-sub:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watchLoop never broadcast a reload after the atomic-save Remove+Create sequence")
+	}
+}
@@ -0,0 +1,128 @@
+package mdweb
+
+import "errors"
+import "testing"
+import "testing/fstest"
+
+func TestProcessFilesMemOutputSinkStaysInFSPathSpace(t *testing.T) {
+	fsys := fstest.MapFS{
+		"demo/test.mdw": &fstest.MapFile{Data: []byte(
+			"Prose.\n\n\t<<out.go>>\n\tfmt.Println(\"hi\")\n",
+		)},
+	}
+
+	sink := NewMemOutputSink()
+
+	err := ProcessFiles(WeaveConfig{
+		FS: fsys,
+		Output: sink,
+		WriteCode: true,
+		Patterns: []string{"demo/*.mdw"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "demo/out.go"
+	if _, ok := sink.Files[want]; !ok {
+		var got []string
+		for k := range sink.Files {
+			got = append(got, k)
+		}
+		t.Fatalf("MemOutputSink.Files = %v, want a key %q rooted in the virtual FS, not the host filesystem", got, want)
+	}
+}
+
+func TestProcessFilesTanglesOnlyRootChunks(t *testing.T) {
+	fsys := fstest.MapFS{
+		"demo/test.mdw": &fstest.MapFile{Data: []byte(
+			"Prose.\n\n\t<<out.go>>\n\tfunc main() {\n\t<<greet>>\n\t}\n\nMore prose.\n\n\t<<greet>>\n\tfmt.Println(\"hi\")\n",
+		)},
+	}
+
+	sink := NewMemOutputSink()
+
+	err := ProcessFiles(WeaveConfig{
+		FS: fsys,
+		Output: sink,
+		WriteCode: true,
+		Patterns: []string{"demo/*.mdw"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := sink.Files["demo/out.go"]; !ok {
+		t.Fatalf("MemOutputSink.Files = %v, want demo/out.go", sink.Files)
+	}
+	if _, ok := sink.Files["demo/greet"]; ok {
+		t.Fatalf("MemOutputSink.Files = %v, greet is only ever referenced and shouldn't get its own file", sink.Files)
+	}
+}
+
+func TestProcessFilesStillReportsACycleWithNoRoot(t *testing.T) {
+	fsys := fstest.MapFS{
+		"demo/test.mdw": &fstest.MapFile{Data: []byte(
+			"Prose.\n\n\t<<a>>\n\tX\n\t<<b>>\n\nMore prose.\n\n\t<<b>>\n\tY\n\t<<a>>\n",
+		)},
+	}
+
+	err := ProcessFiles(WeaveConfig{
+		FS: fsys,
+		Output: NewMemOutputSink(),
+		WriteCode: true,
+		Patterns: []string{"demo/*.mdw"},
+	})
+	if err == nil {
+		t.Fatal("expected a chunk reference cycle error, got nil")
+	}
+}
+
+func TestProcessFilesFencedPlaceholderIsNotTreatedAsAReference(t *testing.T) {
+	fsys := fstest.MapFS{
+		"demo/test.md": &fstest.MapFile{Data: []byte(
+			"Prose.\n\n```go target=out.go\nconst tmpl = \"<<PLACEHOLDER>>\"\n```\n",
+		)},
+	}
+
+	sink := NewMemOutputSink()
+
+	err := ProcessFiles(WeaveConfig{
+		FS: fsys,
+		Output: sink,
+		WriteCode: true,
+		Patterns: []string{"demo/*.md"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := sink.Files["demo/out.go"].String()
+	if got != "const tmpl = \"<<PLACEHOLDER>>\"\n" {
+		t.Fatalf("demo/out.go = %q, want the fenced line verbatim", got)
+	}
+}
+
+// errReader fails on its first Read, simulating an I/O error partway
+// through a scan.
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("simulated read error")
+}
+
+func TestProcessReaderSurfacesScanErrorAsALine(t *testing.T) {
+	lines, err := ProcessReader(errReader{}, ProcessOptions{Filename: "test.mdw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last Line
+	for line := range lines {
+		last = line
+	}
+
+	if last.Err == nil {
+		t.Fatal("expected the final Line to carry the scan error, got Err == nil")
+	}
+}
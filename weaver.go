@@ -0,0 +1,234 @@
+package mdweb
+
+import "bytes"
+import "fmt"
+import "html/template"
+import "io"
+import "strings"
+
+import "github.com/yuin/goldmark"
+import "github.com/alecthomas/chroma/v2"
+import chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+import "github.com/alecthomas/chroma/v2/lexers"
+import "github.com/alecthomas/chroma/v2/styles"
+
+// A Weaver renders the lines produced by ProcessFile (or ProcessFileWithOptions)
+// into a single documentation output, written to w. TextWeaver reproduces the
+// traditional mdweave output; HTMLWeaver renders a standalone HTML page.
+type Weaver interface {
+	Weave(w io.Writer, filename string, lines <-chan Line) error
+}
+
+var weavers = map[string]Weaver{}
+
+func init() {
+	RegisterWeaver("text", TextWeaver{})
+	RegisterWeaver("html", NewHTMLWeaver(HTMLWeaverOptions{}))
+}
+
+// RegisterWeaver makes w available under name, e.g. for a CLI's -format flag.
+// Registering under an existing name replaces it.
+func RegisterWeaver(name string, w Weaver) {
+	weavers[name] = w
+}
+
+// GetWeaver looks up a Weaver previously passed to RegisterWeaver.
+func GetWeaver(name string) (w Weaver, ok bool) {
+	w, ok = weavers[name]
+	return
+}
+
+// TextWeaver reproduces the file being woven, prefixing code lines with a
+// tab the same way mdweave has always done.
+type TextWeaver struct{}
+
+func (TextWeaver) Weave(w io.Writer, filename string, lines <-chan Line) error {
+	for line := range lines {
+		if line.TextTarget == "" {
+			continue
+		}
+
+		if line.CodeTarget != "" {
+			fmt.Fprint(w, "\t")
+		}
+
+		fmt.Fprint(w, line.Text)
+	}
+
+	return nil
+}
+
+// A Highlighter turns a block of source in the named language into
+// highlighted HTML.
+type Highlighter interface {
+	Highlight(w io.Writer, lang, code string) error
+}
+
+// chromaHighlighter is the default Highlighter, backed by chroma. It emits
+// an inline fragment (classed <code> spans), not a standalone document, so
+// it can be embedded inside a larger page.
+type chromaHighlighter struct{}
+
+// suppressPreWrapper discards chroma's own <pre>...</pre>, leaving only the
+// <code> it wraps: Weave already wraps each block in its own
+// <pre class="mdweb-code" data-target="...">, and a <pre> can't nest inside
+// another <pre>.
+type suppressPreWrapper struct{}
+
+func (suppressPreWrapper) Start(code bool, styleAttr string) string {
+	if code {
+		return "<code>"
+	}
+	return ""
+}
+
+func (suppressPreWrapper) End(code bool) string {
+	if code {
+		return "</code>"
+	}
+	return ""
+}
+
+func (chromaHighlighter) Highlight(w io.Writer, lang, code string) error {
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, code)
+	if err != nil {
+		return err
+	}
+
+	style := styles.Get("github")
+	if style == nil {
+		style = styles.Fallback
+	}
+
+	formatter := chromahtml.New(chromahtml.WithClasses(true), chromahtml.WithPreWrapper(suppressPreWrapper{}))
+	return formatter.Format(w, style, iterator)
+}
+
+// HTMLWeaverOptions customizes an HTMLWeaver. A nil field falls back to the
+// default highlighter or template.
+type HTMLWeaverOptions struct {
+	Highlighter Highlighter
+	Template *template.Template
+}
+
+// HTMLWeaver renders prose through goldmark and wraps each code region in a
+// <pre class="mdweb-code" data-target="..."> block, highlighted via
+// Highlighter, producing a standalone HTML document.
+type HTMLWeaver struct {
+	highlighter Highlighter
+	template *template.Template
+}
+
+func NewHTMLWeaver(opts HTMLWeaverOptions) *HTMLWeaver {
+	highlighter := opts.Highlighter
+	if highlighter == nil {
+		highlighter = chromaHighlighter{}
+	}
+
+	tmpl := opts.Template
+	if tmpl == nil {
+		tmpl = template.Must(template.New("mdweb-html").Parse(defaultHTMLWeaverTemplate))
+	}
+
+	return &HTMLWeaver{highlighter: highlighter, template: tmpl}
+}
+
+// htmlBlock is a run of consecutive Lines that are either all code for the
+// same CodeTarget, or all prose.
+type htmlBlock struct {
+	isCode bool
+	target string
+	lang string
+	text strings.Builder
+}
+
+func (b *htmlBlock) append(line Line) {
+	if b.isCode {
+		b.text.WriteString(line.Code)
+	} else {
+		b.text.WriteString(line.Text)
+	}
+	b.text.WriteString("\n")
+}
+
+func (hw *HTMLWeaver) Weave(w io.Writer, filename string, lines <-chan Line) error {
+	var buffered []Line
+	for line := range lines {
+		buffered = append(buffered, line)
+	}
+
+	body, err := hw.renderBody(buffered)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		Filename string
+		Body template.HTML
+	}{
+		Filename: filename,
+		Body: body,
+	}
+
+	return hw.template.Execute(w, data)
+}
+
+// renderBody groups lines into contiguous prose/code blocks and renders
+// them: prose through goldmark, code through hw.highlighter. Weave calls
+// this for its own Body; server.load (same package) also calls it directly,
+// so the live page renders identically to mdweave's -format html output.
+func (hw *HTMLWeaver) renderBody(lines []Line) (template.HTML, error) {
+	var blocks []*htmlBlock
+
+	for _, line := range lines {
+		isCode := line.CodeTarget != ""
+
+		if n := len(blocks); n > 0 && blocks[n-1].isCode == isCode && blocks[n-1].target == line.CodeTarget {
+			blocks[n-1].append(line)
+			continue
+		}
+
+		b := &htmlBlock{isCode: isCode, target: line.CodeTarget, lang: line.Lang}
+		b.append(line)
+		blocks = append(blocks, b)
+	}
+
+	var body bytes.Buffer
+
+	for _, b := range blocks {
+		if b.isCode {
+			fmt.Fprintf(&body, "<pre class=\"mdweb-code\" data-target=%q>", b.target)
+			if err := hw.highlighter.Highlight(&body, b.lang, b.text.String()); err != nil {
+				return "", err
+			}
+			body.WriteString("</pre>\n")
+		} else {
+			if err := goldmark.Convert([]byte(b.text.String()), &body); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return template.HTML(body.String()), nil
+}
+
+const defaultHTMLWeaverTemplate = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{.Filename}}</title>
+</head>
+<body>
+	{{.Body}}
+</body>
+</html>
+`
@@ -0,0 +1,81 @@
+package mdweb
+
+import "bytes"
+import "io"
+import "strings"
+import "testing"
+
+// stubHighlighter records the lang/code it was asked to highlight and emits
+// a recognizable marker instead of running a real highlighter.
+type stubHighlighter struct {
+	calls []string
+}
+
+func (h *stubHighlighter) Highlight(w io.Writer, lang, code string) error {
+	h.calls = append(h.calls, lang+":"+code)
+	_, err := w.Write([]byte("<code>" + code + "</code>"))
+	return err
+}
+
+func weaveLines(t *testing.T, highlighter Highlighter, lines []Line) string {
+	t.Helper()
+
+	ch := make(chan Line)
+	go func() {
+		defer close(ch)
+		for _, line := range lines {
+			ch <- line
+		}
+	}()
+
+	weaver := NewHTMLWeaver(HTMLWeaverOptions{Highlighter: highlighter})
+
+	var out bytes.Buffer
+	if err := weaver.Weave(&out, "test.md", ch); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestHTMLWeaverWrapsCodeInPreNotDiv(t *testing.T) {
+	stub := &stubHighlighter{}
+	out := weaveLines(t, stub, []Line{
+		{Text: "Prose."},
+		{Code: "fmt.Println(\"hi\")", CodeTarget: "main.go", Lang: "go"},
+	})
+
+	if !strings.Contains(out, `<pre class="mdweb-code" data-target="main.go">`) {
+		t.Fatalf("output missing <pre class=\"mdweb-code\" data-target=\"main.go\">: %s", out)
+	}
+	if strings.Contains(out, "mdweb-code\" data-target") && strings.Contains(out, "<div") {
+		t.Fatalf("code region still wrapped in a <div>: %s", out)
+	}
+}
+
+func TestHTMLWeaverThreadsLangIntoHighlighter(t *testing.T) {
+	stub := &stubHighlighter{}
+	weaveLines(t, stub, []Line{
+		{Code: "fmt.Println(\"hi\")", CodeTarget: "main.go", Lang: "go"},
+	})
+
+	if len(stub.calls) != 1 || stub.calls[0] != "go:fmt.Println(\"hi\")\n" {
+		t.Fatalf("Highlight calls = %#v, want a single go: call", stub.calls)
+	}
+}
+
+func TestHTMLWeaverGroupsConsecutiveLinesIntoOneBlock(t *testing.T) {
+	stub := &stubHighlighter{}
+	weaveLines(t, stub, []Line{
+		{Code: "a", CodeTarget: "main.go", Lang: "go"},
+		{Code: "b", CodeTarget: "main.go", Lang: "go"},
+		{Text: "Prose."},
+		{Code: "c", CodeTarget: "main.go", Lang: "go"},
+	})
+
+	if len(stub.calls) != 2 {
+		t.Fatalf("Highlight calls = %#v, want 2 (one per contiguous run)", stub.calls)
+	}
+	if stub.calls[0] != "go:a\nb\n" {
+		t.Fatalf("calls[0] = %q, want %q", stub.calls[0], "go:a\nb\n")
+	}
+}